@@ -6,7 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
-	"gitlab.eng.vmware.com/vivienv/flare/script"
+	"github.com/vmware-tanzu/crash-diagnostics/script"
 )
 
 func TestExecCAPTURE(t *testing.T) {
@@ -26,7 +26,7 @@ func TestExecCAPTURE(t *testing.T) {
 					return err
 				}
 
-				fileName := filepath.Join(workdir.Dir(), machine, fmt.Sprintf("%s.txt", flatCmd(capCmd.GetCliString())))
+				fileName := filepath.Join(workdir.Dir(), machine, fmt.Sprintf("%s.stdout.txt", flatCmd(capCmd.GetCliString())))
 				if _, err := os.Stat(fileName); err != nil {
 					return err
 				}
@@ -49,8 +49,8 @@ func TestExecCAPTURE(t *testing.T) {
 					return err
 				}
 
-				fname0 := filepath.Join(workdir.Dir(), machine, fmt.Sprintf("%s.txt", flatCmd(cmd0.GetCliString())))
-				fname1 := filepath.Join(workdir.Dir(), machine, fmt.Sprintf("%s.txt", flatCmd(cmd1.GetCliString())))
+				fname0 := filepath.Join(workdir.Dir(), machine, fmt.Sprintf("%s.stdout.txt", flatCmd(cmd0.GetCliString())))
+				fname1 := filepath.Join(workdir.Dir(), machine, fmt.Sprintf("%s.stdout.txt", flatCmd(cmd1.GetCliString())))
 				if _, err := os.Stat(fname0); err != nil {
 					return err
 				}
@@ -76,7 +76,7 @@ func TestExecCAPTURE(t *testing.T) {
 					return err
 				}
 
-				fileName := filepath.Join(workdir.Dir(), machine, fmt.Sprintf("%s.txt", flatCmd(capCmd.GetCliString())))
+				fileName := filepath.Join(workdir.Dir(), machine, fmt.Sprintf("%s.stdout.txt", flatCmd(capCmd.GetCliString())))
 				if _, err := os.Stat(fileName); err != nil {
 					return err
 				}
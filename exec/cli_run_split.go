@@ -0,0 +1,101 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	osexec "os/exec"
+	"strings"
+	"syscall"
+)
+
+// CliRun runs name/args as uid:gid with env and returns a single reader
+// combining stdout and stderr; the exit code of a command that ran is not
+// reported. CAPTURE and RUN use CliRunSplit instead, which keeps the two
+// streams independent and returns the exit code.
+func CliRun(uid, gid uint32, env []string, name string, args ...string) (io.Reader, error) {
+	c := osexec.Command(name, args...)
+	c.Env = env
+	c.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uid, Gid: gid}}
+
+	var buf bytes.Buffer
+	c.Stdout = &buf
+	c.Stderr = &buf
+
+	if err := c.Run(); err != nil {
+		if _, ok := err.(*osexec.ExitError); !ok {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return &buf, nil
+}
+
+// flatCmd derives a filesystem-safe base name from a CAPTURE action's CLI
+// string (e.g. "/bin/echo 'HELLO WORLD'" -> "bin_echo_HELLO_WORLD"), used
+// to name its output files uniquely within a machine's output subtree.
+func flatCmd(cmdStr string) string {
+	replacer := strings.NewReplacer("/", "_", " ", "_", "'", "", `"`, "")
+	return strings.Trim(replacer.Replace(strings.TrimSpace(cmdStr)), "_")
+}
+
+// CliRunSplit runs name/args as uid:gid with env and returns independent
+// buffers for stdout and stderr plus the process exit code, instead of
+// CliRun's single combined reader. CAPTURE uses this so stdout and stderr
+// can be written to separate files; RUN uses it for the exit code it
+// records in trace.log.
+func CliRunSplit(uid, gid uint32, env []string, name string, args ...string) (stdout, stderr *bytes.Buffer, exitCode int, err error) {
+	c := osexec.Command(name, args...)
+	c.Env = env
+	c.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uid, Gid: gid}}
+
+	var outBuf, errBuf bytes.Buffer
+	c.Stdout = &outBuf
+	c.Stderr = &errBuf
+
+	if runErr := c.Run(); runErr != nil {
+		if exitErr, ok := runErr.(*osexec.ExitError); ok {
+			return &outBuf, &errBuf, exitErr.ExitCode(), nil
+		}
+		return nil, nil, 0, fmt.Errorf("%s: %s", name, runErr)
+	}
+	return &outBuf, &errBuf, 0, nil
+}
+
+// prefixWriter prepends prefix to the start of every line written to w, so
+// stderr forwarded from parallel captures stays attributable to its
+// machine.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	atStart bool
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: prefix, atStart: true}
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		if p.atStart && p.prefix != "" {
+			if _, err := io.WriteString(p.w, p.prefix); err != nil {
+				return written, err
+			}
+			p.atStart = false
+		}
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			n, err := p.w.Write(data)
+			written += n
+			return written, err
+		}
+		n, err := p.w.Write(data[:idx+1])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		data = data[idx+1:]
+		p.atStart = true
+	}
+	return written, nil
+}
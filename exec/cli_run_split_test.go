@@ -0,0 +1,20 @@
+package exec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newPrefixWriter(&out, "[host1] ")
+
+	if _, err := w.Write([]byte("line one\nline two\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[host1] line one\n[host1] line two\n"
+	if got := out.String(); got != want {
+		t.Errorf("prefixWriter output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/crash-diagnostics/parser"
+)
+
+// buildScript parses a script with an explicit, per-test WORKDIR so
+// parallel tests don't collide on Defaults.WorkdirValue.
+func buildScript(t *testing.T, workdir string, body string) *Executor {
+	t.Helper()
+	source := fmt.Sprintf("WORKDIR %s\n%s", workdir, body)
+	s, err := parser.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	return New(s)
+}
+
+// TestExecuteJobsBoundsConcurrency asserts JOBS actually lets independent
+// FROM sources overlap: four sources each sleeping briefly finish in about
+// one sleep's worth of wall-clock time when run with enough JOBS to cover
+// them all, versus roughly four sleeps' worth run sequentially (JOBS 1,
+// the default). This exercises the semaphore-bounded worker pool end to
+// end rather than asserting on an exact duration, which would be flaky.
+func TestExecuteJobsBoundsConcurrency(t *testing.T) {
+	const sources = "m1 m2 m3 m4"
+	const sleepCmd = "CAPTURE /bin/sh -c 'sleep 0.2'"
+
+	run := func(jobs int) time.Duration {
+		workdir := t.TempDir()
+		e := buildScript(t, workdir, fmt.Sprintf("FROM %s\nJOBS %d\n%s", sources, jobs, sleepCmd))
+		start := time.Now()
+		if err := e.Execute(); err != nil {
+			t.Fatalf("jobs=%d: %s", jobs, err)
+		}
+		return time.Since(start)
+	}
+
+	sequential := run(1)
+	parallel := run(4)
+
+	if parallel >= sequential {
+		t.Errorf("JOBS 4 (%s) did not run faster than JOBS 1 (%s); sources are not overlapping", parallel, sequential)
+	}
+}
+
+// TestExecuteFailFastCancelsPeerSources asserts that once a source's
+// action errors, --fail-fast (Executor.FailFast) cancels the shared
+// context before later sources in the FROM list start, instead of
+// letting every source run to completion independently.
+func TestExecuteFailFastCancelsPeerSources(t *testing.T) {
+	workdir := t.TempDir()
+	e := buildScript(t, workdir, "FROM m1 m2 m3\nJOBS 1\nCAPTURE /no/such/binary-xyz")
+	e.FailFast = true
+
+	if err := e.Execute(); err == nil {
+		t.Fatal("expected an error from the missing binary")
+	}
+
+	if _, err := os.Stat(filepath.Join(workdir, "m2")); !os.IsNotExist(err) {
+		t.Errorf("m2: expected no work to have started after m1 failed with --fail-fast, got err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workdir, "m3")); !os.IsNotExist(err) {
+		t.Errorf("m3: expected no work to have started after m1 failed with --fail-fast, got err=%v", err)
+	}
+}
+
+// TestSyncWriterSerializesConcurrentWrites asserts syncWriter - the single
+// writer parallel workers forward stderr through - never interleaves two
+// concurrent Write calls, which would otherwise garble output from
+// multiple captures running at once.
+func TestSyncWriterSerializesConcurrentWrites(t *testing.T) {
+	var buf bytes.Buffer
+	w := &syncWriter{w: &buf}
+
+	const writers = 20
+	line := strings.Repeat("x", 256) + "\n"
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := w.Write([]byte(line)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := buf.String()
+	for _, l := range strings.SplitAfter(got, "\n") {
+		if l == "" {
+			continue
+		}
+		if l != line {
+			t.Fatalf("write was split/interleaved by a concurrent writer: got %q", l)
+		}
+	}
+	if want := writers * len(line); len(got) != want {
+		t.Errorf("total bytes written = %d, want %d", len(got), want)
+	}
+}
@@ -0,0 +1,43 @@
+package exec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vmware-tanzu/crash-diagnostics/parser"
+	"github.com/vmware-tanzu/crash-diagnostics/script"
+)
+
+// execTest is one parse-then-exercise case shared by this package's
+// table-driven tests: source produces the script text to parse, and exec
+// is handed the parsed *script.Script to drive (usually by constructing an
+// Executor and calling Execute, then asserting on its side effects).
+type execTest struct {
+	name       string
+	source     func() string
+	exec       func(s *script.Script) error
+	shouldFail bool
+}
+
+func runExecutorTest(t *testing.T, test execTest) {
+	t.Helper()
+
+	s, err := parser.Parse(strings.NewReader(test.source()))
+	if err != nil {
+		if test.shouldFail {
+			return
+		}
+		t.Fatalf("parse: %s", err)
+	}
+
+	err = test.exec(s)
+	if test.shouldFail {
+		if err == nil {
+			t.Fatal("expected failure, got nil")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
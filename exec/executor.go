@@ -1,19 +1,64 @@
 package exec
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
-	"gitlab.eng.vmware.com/vivienv/flare/script"
+	"github.com/vmware-tanzu/crash-diagnostics/script"
 )
 
 type Executor struct {
 	script *script.Script
+	remote *RemoteExecutor
+
+	// Silent suppresses live stderr passthrough from captured commands.
+	// Logs retains each command's stderr file even when it exits 0 (the
+	// default drops it on success). StderrPrefix is prepended to each
+	// forwarded stderr line so parallel captures stay readable; a literal
+	// "<machine>" occurrence is replaced with the source's address (e.g.
+	// "[<machine>] "), and a prefix without one is used verbatim. All
+	// three are meant to be settable via --silent/--logs/--stderr-prefix
+	// in addition to a LOGS preamble, but that CLI wiring has nowhere to
+	// live: this reviewable tree has no cmd/main package, so for now these
+	// three can only be set by constructing an Executor directly in Go;
+	// whatever CLI entrypoint this repo ships under must set them itself
+	// before calling Execute. A LOGS preamble in the script overrides
+	// whatever these were set to.
+	Silent       bool
+	Logs         bool
+	StderrPrefix string
+
+	// Jobs bounds how many FROM sources run concurrently (default 1, i.e.
+	// sequential); a JOBS preamble in the script overrides it. FailFast
+	// cancels peer workers as soon as one source returns an error instead
+	// of letting every source run to completion. As with Silent/Logs/
+	// StderrPrefix above, there is no CLI entrypoint in this tree to parse
+	// --jobs/--fail-fast from: Jobs is set in Go or by a JOBS preamble, and
+	// FailFast, which has no preamble form, only in Go.
+	Jobs     int
+	FailFast bool
+
+	// Trace logs every resolved CAPTURE/COPY/RUN action - substituted env,
+	// effective uid/gid, expanded argv - plus its duration and exit status,
+	// to workdir/trace.log. DryRun performs the same resolution but skips
+	// CliRun/CliRunSplit/remote dispatch entirely, so a script (including
+	// its FROM/AS/KUBECONFIG defaults) can be validated without side
+	// effects. Both are meant to be settable via --trace/-x/--dry-run;
+	// like the fields above, that CLI wiring doesn't exist anywhere in
+	// this tree, so for now they're Go-only knobs.
+	Trace  bool
+	DryRun bool
 }
 
 func New(src *script.Script) *Executor {
@@ -51,6 +96,13 @@ func (e *Executor) Execute() error {
 	}
 	logrus.Debugf("Using workdir %s", workdir.Dir())
 
+	// load the artifact manifest (if any) so unchanged COPY/CAPTURE actions
+	// can be skipped on a resumed run
+	manifest, err := LoadManifest(filepath.Join(workdir.Dir(), "manifest.rec"))
+	if err != nil {
+		return fmt.Errorf("manifest: %s", err)
+	}
+
 	// setup ENV
 	var envPairs []string
 	envCmds := e.script.Preambles[script.CmdEnv]
@@ -63,94 +115,421 @@ func (e *Executor) Execute() error {
 		}
 	}
 
-	// process action for each FROM source
+	// LOGS preamble sets stderr retention/forwarding policy (mirrors
+	// goredo's REDO_LOGS/REDO_SILENT/REDO_STDERR_PREFIX envs)
+	if logsCmds, ok := e.script.Preambles[script.CmdLogs]; ok {
+		logsCmd := logsCmds[0].(*script.LogsCommand)
+		e.Silent = logsCmd.Silent()
+		e.Logs = logsCmd.Logs()
+		e.StderrPrefix = logsCmd.StderrPrefix()
+	}
+
+	// setup REMOTE (optional): when present, CAPTURE/RUN actions dispatch
+	// through the CAS-keyed action pipeline described by dialRemote instead
+	// of running locally.
+	if remoteCmds, ok := e.script.Preambles[script.CmdRemote]; ok {
+		remoteCmd := remoteCmds[0].(*script.RemoteCommand)
+		cas, execClient, err := dialRemote(remoteCmd, workdir.Dir())
+		if err != nil {
+			return fmt.Errorf("remote: %s", err)
+		}
+		e.remote = NewRemoteExecutor(remoteCmd, cas, execClient, workdir.Dir())
+		logrus.Debugf("Dispatching CAPTURE/RUN to remote %s (instance %s)", remoteCmd.Address(), remoteCmd.Instance())
+	}
+
+	// JOBS preamble bounds how many FROM sources run concurrently (mirrors
+	// goredo's Jobs pattern); a value set on the Executor before Execute()
+	// runs still applies when JOBS isn't present.
+	jobs := e.Jobs
+	if jobsCmds, ok := e.script.Preambles[script.CmdJobs]; ok {
+		jobs = jobsCmds[0].(*script.JobsCommand).N()
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	// --trace/--dry-run both record every resolved action to trace.log
+	var tracer *traceRecorder
+	if e.Trace || e.DryRun {
+		t, err := newTraceRecorder(filepath.Join(workdir.Dir(), "trace.log"))
+		if err != nil {
+			return fmt.Errorf("trace: %s", err)
+		}
+		tracer = t
+		defer tracer.Close()
+	}
 
-	for _, fromSrc := range fromCmd.Sources() {
+	// process actions for each FROM source, at most `jobs` at a time; a
+	// worker's own stderr is serialized through stderrOut so concurrent
+	// captures don't interleave, and manifest access is guarded by
+	// manifestMu since every worker appends to the same Manifest.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		for _, action := range e.script.Actions {
-			switch cmd := action.(type) {
-			case *script.CopyCommand:
-				// TODO - COPY uses a go implementation which means uid/guid
-				// for the COPY cmd cannot be applied using the flare file.
-				// This may need to be changed to a os/cmd external call
+	sources := fromCmd.Sources()
+	sem := make(chan struct{}, jobs)
+	stderrOut := &syncWriter{w: os.Stderr}
 
-				// walk each arg and copy to workdir
-				for _, path := range cmd.Args() {
-					if relPath, err := filepath.Rel(workdir.Dir(), path); err == nil && !strings.HasPrefix(relPath, "..") {
-						logrus.Errorf("%s path %s cannot be relative to workdir %s", cmd.Name(), path, workdir.Dir())
-						continue
+	var wg sync.WaitGroup
+	var manifestMu sync.Mutex
+	var errsMu sync.Mutex
+	var errs []error
+
+	for _, src := range sources {
+		src := src
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			machineDir := filepath.Join(workdir.Dir(), src.Address)
+			if err := os.MkdirAll(machineDir, 0744); err != nil && !os.IsExist(err) {
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %s", src.Address, err))
+				errsMu.Unlock()
+				if e.FailFast {
+					cancel()
+				}
+				return
+			}
+
+			if err := e.runActionsForSource(ctx, src, machineDir, uint32(asUid), uint32(asGid), envPairs, manifest, &manifestMu, stderrOut, tracer); err != nil {
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %s", src.Address, err))
+				errsMu.Unlock()
+				if e.FailFast {
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// bundle into a single txtar archive when OUTPUT format:txtar is set
+	if outCmds, ok := e.script.Preambles[script.CmdOutput]; ok {
+		outCmd := outCmds[0].(*script.OutputCommand)
+		if outCmd.Format() == "txtar" {
+			logrus.Debugf("Bundling %s into txtar archive %s", workdir.Dir(), outCmd.Path())
+			if err := bundleTxtar(workdir.Dir(), outCmd.Path()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := manifest.Save(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return multiError(errs)
+}
+
+// runActionsForSource runs every script action against a single FROM
+// source, writing captured output under machineDir. It owns its own
+// copiedPaths/pendingDeps state so it can run concurrently with its peers;
+// manifest reads/writes are guarded by manifestMu since the Manifest is
+// shared across all workers.
+func (e *Executor) runActionsForSource(ctx context.Context, src script.Machine, machineDir string, asUid, asGid uint32, envPairs []string, manifest *Manifest, manifestMu *sync.Mutex, stderrOut io.Writer, tracer *traceRecorder) error {
+	var copiedPaths []string
+	var pendingDeps []string
+
+	for _, action := range e.script.Actions {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		switch cmd := action.(type) {
+		case *script.IfChangeCommand:
+			// declares extra digest dependencies for the CAPTURE that follows
+			pendingDeps = append(pendingDeps, cmd.Paths()...)
+		case *script.CopyCommand:
+			// TODO - COPY uses a go implementation which means uid/guid
+			// for the COPY cmd cannot be applied using the flare file.
+			// This may need to be changed to a os/cmd external call
+
+			// walk each arg and copy to machineDir
+			for _, path := range cmd.Args() {
+				if relPath, err := filepath.Rel(machineDir, path); err == nil && !strings.HasPrefix(relPath, "..") {
+					logrus.Errorf("%s path %s cannot be relative to workdir %s", cmd.Name(), path, machineDir)
+					continue
+				}
+
+				if e.Trace || e.DryRun {
+					logrus.Infof("[%s] COPY %s", src.Address, path)
+				}
+				if tracer != nil {
+					now := time.Now()
+					if err := tracer.Record(traceEntry{Machine: src.Address, Action: "COPY", Argv: []string{path}, StartedAt: now, FinishedAt: now, DryRun: e.DryRun}); err != nil {
+						return err
+					}
+				}
+				if e.DryRun {
+					continue
+				}
+
+				copyKey := fmt.Sprintf("COPY %s", path)
+				unchanged := false
+				manifestMu.Lock()
+				if digest, size, err := sha256File(path); err == nil {
+					var mtime time.Time
+					if info, statErr := os.Stat(path); statErr == nil {
+						mtime = info.ModTime()
+					}
+					if prev, ok := manifest.Lookup(src.Address, copyKey); ok && prev.Sha256 == digest && prev.Size == size && prev.Mtime.Equal(mtime) {
+						unchanged = true
+					} else {
+						manifest.Append(ManifestRecord{Machine: src.Address, Command: copyKey, File: path, Sha256: digest, Size: size, Mtime: mtime, FinishedAt: time.Now()})
 					}
-					logrus.Debugf("Copying content from %s", path)
+				}
+				manifestMu.Unlock()
+
+				// copiedPaths still gets path even when unchanged: REMOTE's
+				// input root is built from this slice, so a resumed run
+				// must still ship an unchanged COPY source to it even
+				// though the local copy below is skipped.
+				copiedPaths = append(copiedPaths, path)
+				if unchanged {
+					logrus.Debugf("Skipping unchanged COPY source %s", path)
+					continue
+				}
+
+				logrus.Debugf("Copying content from %s", path)
 
-					err := filepath.Walk(path, func(file string, finfo os.FileInfo, err error) error {
+				err := filepath.Walk(path, func(file string, finfo os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					//TODO subpath calculation flattens the file source, that's wrong.
+					// subpath should include full path of file, not just the base.
+					subpath := filepath.Join(machineDir, filepath.Base(file))
+					switch {
+					case finfo.Mode().IsDir():
+						if err := os.MkdirAll(subpath, 0744); err != nil && !os.IsExist(err) {
+							return err
+						}
+						logrus.Debugf("Created subpath %s", subpath)
+						return nil
+					case finfo.Mode().IsRegular():
+						logrus.Debugf("Copying %s -> %s", file, subpath)
+						srcFile, err := os.Open(file)
 						if err != nil {
 							return err
 						}
-						//TODO subpath calculation flattens the file source, that's wrong.
-						// subpath should include full path of file, not just the base.
-						subpath := filepath.Join(workdir.Dir(), filepath.Base(file))
-						switch {
-						case finfo.Mode().IsDir():
-							if err := os.MkdirAll(subpath, 0744); err != nil && !os.IsExist(err) {
-								return err
-							}
-							logrus.Debugf("Created subpath %s", subpath)
-							return nil
-						case finfo.Mode().IsRegular():
-							logrus.Debugf("Copying %s -> %s", file, subpath)
-							srcFile, err := os.Open(file)
-							if err != nil {
-								return err
-							}
-							defer srcFile.Close()
-
-							desFile, err := os.Create(subpath)
-							if err != nil {
-								return err
-							}
-							n, err := io.Copy(desFile, srcFile)
-							if closeErr := desFile.Close(); closeErr != nil {
-								return closeErr
-							}
-							if err != nil {
-								return err
-							}
-
-							if n != finfo.Size() {
-								return fmt.Errorf("%s did not complet for %s", cmd.Name, file)
-							}
-						default:
-							return fmt.Errorf("%s unknown file type for %s", cmd.Name, file)
+						defer srcFile.Close()
+
+						desFile, err := os.Create(subpath)
+						if err != nil {
+							return err
+						}
+						n, err := io.Copy(desFile, srcFile)
+						if closeErr := desFile.Close(); closeErr != nil {
+							return closeErr
+						}
+						if err != nil {
+							return err
 						}
-						return nil
-					})
 
-					if err != nil {
-						logrus.Error(err)
+						if n != finfo.Size() {
+							return fmt.Errorf("%s did not complet for %s", cmd.Name(), file)
+						}
+					default:
+						return fmt.Errorf("%s unknown file type for %s", cmd.Name(), file)
+					}
+					return nil
+				})
+
+				if err != nil {
+					logrus.Error(err)
+				}
+			}
+		case *script.CaptureCommand:
+			// capture command output
+			cmdStr := cmd.GetCliString()
+			logrus.Debugf("Parsing CLI command %v", cmdStr)
+			cliCmd, cliArgs := cmd.GetParsedCli()
+			argv := append([]string{cliCmd}, cliArgs...)
+			deps := pendingDeps
+			pendingDeps = nil
+
+			// IFCHANGE is opt-in: with no declared deps there's nothing to
+			// compare against, so the command always re-runs rather than
+			// being skipped forever after its first successful run.
+			//
+			// depsUnchanged is called unconditionally so a brand-new
+			// IFCHANGE dep gets its baseline digest recorded on this very
+			// run; only the skip decision itself is gated on seen.
+			manifestMu.Lock()
+			_, seen := manifest.Lookup(src.Address, cmdStr)
+			depsOK := depsUnchanged(manifest, src.Address, deps)
+			unchanged := len(deps) > 0 && seen && depsOK
+			manifestMu.Unlock()
+			if unchanged {
+				logrus.Debugf("Skipping unchanged CAPTURE %s", cmdStr)
+				continue
+			}
+
+			if e.Trace || e.DryRun {
+				logrus.Infof("[%s] CAPTURE argv=%v env=%v uid=%d gid=%d", src.Address, argv, envPairs, asUid, asGid)
+			}
+
+			if e.DryRun {
+				now := time.Now()
+				if tracer != nil {
+					if err := tracer.Record(traceEntry{Machine: src.Address, Action: "CAPTURE", Argv: argv, Env: envPairs, Uid: asUid, Gid: asGid, StartedAt: now, FinishedAt: now, DryRun: true}); err != nil {
+						return err
 					}
 				}
-			case *script.CaptureCommand:
-				// capture command output
-				cmdStr := cmd.GetCliString()
-				logrus.Debugf("Parsing CLI command %v", cmdStr)
-				cliCmd, cliArgs := cmd.GetParsedCli()
-				cmdReader, err := CliRun(uint32(asUid), uint32(asGid), envPairs, cliCmd, cliArgs...)
+				continue
+			}
+
+			if e.remote != nil {
+				started := time.Now()
+				exitCode, err := e.remote.Dispatch(ctx, src.Address, flatCmd(cmdStr), asUid, asGid, envPairs, copiedPaths, machineDir, argv...)
 				if err != nil {
 					return err
 				}
-				fileName := fmt.Sprintf("%s.txt", flatCmd(cmdStr))
-				filePath := filepath.Join(workdir.Dir(), fileName)
-				logrus.Debugf("Capturing command out: [%s] -> %s", cmdStr, filePath)
-				if err := writeFile(cmdReader, filePath); err != nil {
+				if tracer != nil {
+					if err := tracer.Record(traceEntry{Machine: src.Address, Action: "CAPTURE", Argv: argv, Env: envPairs, Uid: asUid, Gid: asGid, StartedAt: started, FinishedAt: time.Now(), ExitStatus: int(exitCode)}); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			started := time.Now()
+			base := flatCmd(cmdStr)
+			stdoutPath := filepath.Join(machineDir, base+".stdout.txt")
+			stderrPath := filepath.Join(machineDir, base+".stderr.txt")
+			exitPath := filepath.Join(machineDir, base+".exit")
+
+			stdout, stderr, exitCode, err := CliRunSplit(asUid, asGid, envPairs, cliCmd, cliArgs...)
+			if err != nil {
+				return err
+			}
+			logrus.Debugf("Capturing command out: [%s] -> %s", cmdStr, stdoutPath)
+			if err := writeFile(stdout, stdoutPath); err != nil {
+				return err
+			}
+
+			stderrDest := io.Writer(ioutil.Discard)
+			var stderrCopy bytes.Buffer
+			if e.Logs || exitCode != 0 {
+				stderrDest = &stderrCopy
+			}
+			if !e.Silent {
+				prefix := strings.Replace(e.StderrPrefix, "<machine>", src.Address, 1)
+				stderrDest = io.MultiWriter(stderrDest, newPrefixWriter(stderrOut, prefix))
+			}
+			if _, err := io.Copy(stderrDest, stderr); err != nil {
+				return err
+			}
+			if e.Logs || exitCode != 0 {
+				if err := ioutil.WriteFile(stderrPath, stderrCopy.Bytes(), 0644); err != nil {
 					return err
 				}
-			default:
 			}
+			if err := ioutil.WriteFile(exitPath, []byte(strconv.Itoa(exitCode)), 0644); err != nil {
+				return err
+			}
+
+			digest, size, err := sha256File(stdoutPath)
+			if err != nil {
+				return err
+			}
+			finished := time.Now()
+			manifestMu.Lock()
+			manifest.Append(ManifestRecord{
+				Machine: src.Address, Command: cmdStr, File: stdoutPath,
+				Sha256: digest, Size: size, ExitStatus: exitCode, StartedAt: started, FinishedAt: finished,
+			})
+			manifestMu.Unlock()
+			if tracer != nil {
+				if err := tracer.Record(traceEntry{Machine: src.Address, Action: "CAPTURE", Argv: argv, Env: envPairs, Uid: asUid, Gid: asGid, StartedAt: started, FinishedAt: finished, ExitStatus: exitCode}); err != nil {
+					return err
+				}
+			}
+		case *script.RunCommand:
+			// run command, discarding output but honoring a remote backend
+			cliCmd, cliArgs := cmd.GetParsedCli()
+			argv := append([]string{cliCmd}, cliArgs...)
+
+			if e.Trace || e.DryRun {
+				logrus.Infof("[%s] RUN argv=%v env=%v uid=%d gid=%d", src.Address, argv, envPairs, asUid, asGid)
+			}
+			if e.DryRun {
+				now := time.Now()
+				if tracer != nil {
+					if err := tracer.Record(traceEntry{Machine: src.Address, Action: "RUN", Argv: argv, Env: envPairs, Uid: asUid, Gid: asGid, StartedAt: now, FinishedAt: now, DryRun: true}); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			started := time.Now()
+			if e.remote != nil {
+				exitCode, err := e.remote.Dispatch(ctx, src.Address, flatCmd(strings.Join(argv, " ")), asUid, asGid, envPairs, copiedPaths, machineDir, argv...)
+				if err != nil {
+					return err
+				}
+				if tracer != nil {
+					if err := tracer.Record(traceEntry{Machine: src.Address, Action: "RUN", Argv: argv, Env: envPairs, Uid: asUid, Gid: asGid, StartedAt: started, FinishedAt: time.Now(), ExitStatus: int(exitCode)}); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			_, _, exitCode, err := CliRunSplit(asUid, asGid, envPairs, cliCmd, cliArgs...)
+			if err != nil {
+				return err
+			}
+			if tracer != nil {
+				if err := tracer.Record(traceEntry{Machine: src.Address, Action: "RUN", Argv: argv, Env: envPairs, Uid: asUid, Gid: asGid, StartedAt: started, FinishedAt: time.Now(), ExitStatus: exitCode}); err != nil {
+					return err
+				}
+			}
+		default:
 		}
 	}
 	return nil
 }
 
+// syncWriter serializes concurrent writes to w so stderr forwarded from
+// parallel workers doesn't interleave mid-line.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// multiError joins errs into a single error, or returns nil if errs is
+// empty. Workers run to completion independently, so a script targeting
+// many sources reports every failure instead of stopping at the first one.
+func multiError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("%d source(s) failed:\n%s", len(errs), strings.Join(msgs, "\n"))
+}
+
 func writeFile(source io.Reader, filePath string) error {
 	file, err := os.Create(filePath)
 	if err != nil {
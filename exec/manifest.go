@@ -0,0 +1,226 @@
+package exec
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ManifestRecord describes one captured artifact, recorded in recfile
+// (https://www.gnu.org/software/recutils/) format at workdir/manifest.rec so
+// subsequent runs can skip actions whose inputs haven't changed.
+type ManifestRecord struct {
+	Machine    string
+	Command    string
+	File       string
+	Sha256     string
+	Size       int64
+	Mtime      time.Time
+	ExitStatus int
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Manifest is the full set of records for a workdir. Records are keyed
+// informally by Machine+Command; Lookup returns the most recent match so a
+// re-run's skip decisions always see the latest recorded state.
+type Manifest struct {
+	path    string
+	Records []ManifestRecord
+}
+
+// LoadManifest reads path if present; a missing manifest is not an error
+// and yields an empty Manifest ready to be appended to and saved.
+func LoadManifest(path string) (*Manifest, error) {
+	m := &Manifest{path: path}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rec := ManifestRecord{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if rec.Command != "" {
+				m.Records = append(m.Records, rec)
+			}
+			rec = ManifestRecord{}
+			continue
+		}
+		field, value, err := splitRecfileLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: %s: %s", path, err)
+		}
+		switch field {
+		case "Machine":
+			rec.Machine = value
+		case "Command":
+			rec.Command = value
+		case "File":
+			rec.File = value
+		case "Sha256":
+			rec.Sha256 = value
+		case "Size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("manifest: %s: Size: %s", path, err)
+			}
+			rec.Size = size
+		case "Mtime":
+			t, err := parseTAI64N(value)
+			if err != nil {
+				return nil, fmt.Errorf("manifest: %s: Mtime: %s", path, err)
+			}
+			rec.Mtime = t
+		case "ExitStatus":
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("manifest: %s: ExitStatus: %s", path, err)
+			}
+			rec.ExitStatus = status
+		case "StartedAt":
+			t, err := parseTAI64N(value)
+			if err != nil {
+				return nil, fmt.Errorf("manifest: %s: StartedAt: %s", path, err)
+			}
+			rec.StartedAt = t
+		case "FinishedAt":
+			t, err := parseTAI64N(value)
+			if err != nil {
+				return nil, fmt.Errorf("manifest: %s: FinishedAt: %s", path, err)
+			}
+			rec.FinishedAt = t
+		}
+	}
+	if rec.Command != "" {
+		m.Records = append(m.Records, rec)
+	}
+	return m, scanner.Err()
+}
+
+// Save (re)writes the manifest to its recfile path, one record per artifact.
+func (m *Manifest) Save() error {
+	file, err := os.Create(m.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	for i, rec := range m.Records {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Machine: %s\n", rec.Machine)
+		fmt.Fprintf(w, "Command: %s\n", rec.Command)
+		fmt.Fprintf(w, "File: %s\n", rec.File)
+		fmt.Fprintf(w, "Sha256: %s\n", rec.Sha256)
+		fmt.Fprintf(w, "Size: %d\n", rec.Size)
+		if !rec.Mtime.IsZero() {
+			fmt.Fprintf(w, "Mtime: %s\n", formatTAI64N(rec.Mtime))
+		}
+		fmt.Fprintf(w, "ExitStatus: %d\n", rec.ExitStatus)
+		fmt.Fprintf(w, "StartedAt: %s\n", formatTAI64N(rec.StartedAt))
+		fmt.Fprintf(w, "FinishedAt: %s\n", formatTAI64N(rec.FinishedAt))
+	}
+	return w.Flush()
+}
+
+// Append records a completed (or skipped) artifact.
+func (m *Manifest) Append(rec ManifestRecord) {
+	m.Records = append(m.Records, rec)
+}
+
+// Lookup returns the most recently appended record for machine+command, if
+// any.
+func (m *Manifest) Lookup(machine, command string) (ManifestRecord, bool) {
+	for i := len(m.Records) - 1; i >= 0; i-- {
+		if m.Records[i].Machine == machine && m.Records[i].Command == command {
+			return m.Records[i], true
+		}
+	}
+	return ManifestRecord{}, false
+}
+
+// depsUnchanged reports whether every IFCHANGE-declared dep for machine
+// still hashes to its last recorded digest. As a side effect it refreshes
+// the manifest with each dep's current digest so the next run has a
+// baseline to compare against.
+func depsUnchanged(m *Manifest, machine string, deps []string) bool {
+	unchanged := true
+	for _, dep := range deps {
+		key := fmt.Sprintf("IFCHANGE %s", dep)
+		digest, size, err := sha256File(dep)
+		if err != nil {
+			logrus.Warnf("manifest: IFCHANGE %s: %s", dep, err)
+			unchanged = false
+			continue
+		}
+		if prev, ok := m.Lookup(machine, key); !ok || prev.Sha256 != digest {
+			unchanged = false
+		}
+		m.Append(ManifestRecord{Machine: machine, Command: key, File: dep, Sha256: digest, Size: size, FinishedAt: time.Now()})
+	}
+	return unchanged
+}
+
+func splitRecfileLine(line string) (field, value string, err error) {
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		return "", "", fmt.Errorf("malformed line %q", line)
+	}
+	return strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:]), nil
+}
+
+// formatTAI64N renders t in external TAI64N text format, matching the
+// timestamps djb-style tools such as redo write to their logs.
+func formatTAI64N(t time.Time) string {
+	const taiOffset = 1<<62 + 10
+	secs := uint64(t.Unix()) + taiOffset
+	return fmt.Sprintf("@%016x%08x", secs, t.Nanosecond())
+}
+
+func parseTAI64N(s string) (time.Time, error) {
+	if len(s) != 25 || s[0] != '@' {
+		return time.Time{}, fmt.Errorf("malformed TAI64N label %q", s)
+	}
+	const taiOffset = 1<<62 + 10
+	var secs uint64
+	if _, err := fmt.Sscanf(s[1:17], "%016x", &secs); err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if _, err := fmt.Sscanf(s[17:25], "%08x", &nsec); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(secs-taiOffset), nsec).UTC(), nil
+}
+
+// sha256File hashes path's contents and returns the digest plus its size.
+func sha256File(path string) (digest string, size int64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
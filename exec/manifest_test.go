@@ -0,0 +1,78 @@
+package exec
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTAI64NRoundTrip(t *testing.T) {
+	want := time.Now().Round(time.Nanosecond).UTC()
+	label := formatTAI64N(want)
+	got, err := parseTAI64N(label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("TAI64N round trip: got %s, want %s (label %s)", got, want, label)
+	}
+}
+
+func TestManifestSaveLoadRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.rec")
+
+	m := &Manifest{path: path}
+	m.Append(ManifestRecord{
+		Machine: "host1", Command: "/bin/echo HELLO", File: "host1/bin_echo_HELLO.txt",
+		Sha256: "deadbeef", Size: 42, ExitStatus: 0,
+		StartedAt: time.Unix(1000, 0).UTC(), FinishedAt: time.Unix(1001, 0).UTC(),
+	})
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(loaded.Records))
+	}
+	rec, ok := loaded.Lookup("host1", "/bin/echo HELLO")
+	if !ok {
+		t.Fatal("expected to find record for host1")
+	}
+	if rec.Sha256 != "deadbeef" || rec.Size != 42 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+}
+
+func TestDepsUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	depFile := filepath.Join(dir, "dep.txt")
+	if err := ioutil.WriteFile(depFile, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manifest{path: filepath.Join(dir, "manifest.rec")}
+
+	if depsUnchanged(m, "host1", []string{depFile}) {
+		t.Error("expected changed on first observation (no prior digest recorded)")
+	}
+	if !depsUnchanged(m, "host1", []string{depFile}) {
+		t.Error("expected unchanged when the dep's content hasn't changed since the last run")
+	}
+
+	if err := ioutil.WriteFile(depFile, []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if depsUnchanged(m, "host1", []string{depFile}) {
+		t.Error("expected changed after the dep's content was modified")
+	}
+
+	if !depsUnchanged(m, "host1", nil) {
+		t.Error("expected unchanged (trivially true) when no deps are declared")
+	}
+}
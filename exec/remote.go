@@ -0,0 +1,474 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vmware-tanzu/crash-diagnostics/script"
+)
+
+// reBlobStore is the subset of a Bazel Remote Execution API CAS client that
+// RemoteExecutor needs: upload blobs keyed by their sha256 digest and fetch
+// them back. grpcREBackend implements it with the CAS service's
+// BatchUpdateBlobs/BatchReadBlobs RPCs (ByteStream is not used);
+// localREBackend with files in a local directory.
+type reBlobStore interface {
+	Put(ctx context.Context, data []byte) (digest string, size int64, err error)
+	Get(ctx context.Context, digest string, size int64) ([]byte, error)
+}
+
+// reExecutionClient runs a previously uploaded action and reports its
+// result. grpcREBackend implements it with the Execution service's
+// streaming Execute RPC (WaitExecution is not called); localREBackend runs
+// the action on this host.
+type reExecutionClient interface {
+	Execute(ctx context.Context, actionDigest string) (*actionResult, error)
+}
+
+// RemoteExecutor dispatches CAPTURE/RUN actions through a CAS-keyed action
+// pipeline modeled on the Bazel Remote Execution API (upload an input root
+// and a Command, resolve the pair to an Action digest, execute, cache by
+// that digest) instead of running locally or over SSH. It is constructed
+// only when the script declares a REMOTE preamble; Executor falls back to
+// CliRun otherwise.
+//
+// dialRemote wires the cas/exec pair to either localREBackend, a same-host
+// reference implementation (see its doc comment), or grpcREBackend, a real
+// client of an external buildbarn/buildfarm-style RE service, depending on
+// the REMOTE preamble's address. Both satisfy reBlobStore/reExecutionClient,
+// the seam RemoteExecutor drives without caring which transport backs it.
+type RemoteExecutor struct {
+	cas   reBlobStore
+	exec  reExecutionClient
+	cache *actionCache
+}
+
+// NewRemoteExecutor wires a RemoteExecutor for the service described by cmd,
+// rooting its action-result cache under workdir/.remote-cache.
+func NewRemoteExecutor(cmd *script.RemoteCommand, cas reBlobStore, exec reExecutionClient, workdir string) *RemoteExecutor {
+	return &RemoteExecutor{
+		cas:   cas,
+		exec:  exec,
+		cache: newActionCache(filepath.Join(workdir, ".remote-cache")),
+	}
+}
+
+// Dispatch uploads the input root (the files under copySrcs only; no
+// command wrapper file is added to it) and a Command carrying argv, env
+// and uid:gid, runs it remotely, and writes the
+// resulting stdout/stderr/output files under outDir (normally
+// workdir/<machine>/), named "<name>.stdout.txt"/"<name>.stderr.txt" (name
+// is normally flatCmd's rendering of the CAPTURE/RUN command line) so two
+// actions dispatched to the same outDir don't overwrite each other.
+//
+// The action-result cache is scoped by machine in addition to the action
+// digest: argv/env/uid/gid/input-root alone don't vary per FROM source, so
+// two machines running the identical command would otherwise hash to the
+// same action and the second machine's "execution" would be served from
+// the first machine's cached result instead of actually running there.
+func (r *RemoteExecutor) Dispatch(ctx context.Context, machine, name string, uid, gid uint32, env []string, copySrcs []string, outDir string, argv ...string) (int32, error) {
+	inputDigest, inputSize, err := r.uploadInputRoot(ctx, copySrcs)
+	if err != nil {
+		return 0, fmt.Errorf("remote: upload input root: %s", err)
+	}
+
+	actionDigest, err := r.uploadAction(ctx, inputDigest, inputSize, env, uid, gid, argv)
+	if err != nil {
+		return 0, fmt.Errorf("remote: upload action: %s", err)
+	}
+
+	if result, ok := r.cache.Lookup(machine, actionDigest); ok {
+		logrus.Debugf("remote: action %s cached for %s, skipping execution", actionDigest, machine)
+		return result.ExitCode, writeActionResultFiles(result, outDir, name)
+	}
+
+	result, err := r.exec.Execute(ctx, actionDigest)
+	if err != nil {
+		return 0, fmt.Errorf("remote: execute %s: %s", actionDigest, err)
+	}
+	if err := writeActionResultFiles(result, outDir, name); err != nil {
+		return result.ExitCode, err
+	}
+	r.cache.Store(machine, actionDigest, result)
+	return result.ExitCode, nil
+}
+
+// uploadInputRoot walks copySrcs, uploads each regular file's contents to
+// the CAS, and returns the digest and size of the resulting root Directory
+// proto. Each src is rooted under its own "src<N>" directory in the input
+// root (e.g. the 2nd COPY source's "/tmp/work/machine1/proc" contributes
+// paths under "src1/proc/...") so two sources sharing a basename (two
+// "proc" or "config" directories copied from different parents) never
+// collide when the tree is materialized into a fresh directory.
+func (r *RemoteExecutor) uploadInputRoot(ctx context.Context, copySrcs []string) (string, int64, error) {
+	root := newDirNode()
+	for i, src := range copySrcs {
+		srcRoot := fmt.Sprintf("src%d", i)
+		base := filepath.Dir(src)
+		err := filepath.Walk(src, func(file string, finfo os.FileInfo, err error) error {
+			if err != nil || !finfo.Mode().IsRegular() {
+				return err
+			}
+			relPath, err := filepath.Rel(base, file)
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			digest, size, err := r.cas.Put(ctx, data)
+			if err != nil {
+				return err
+			}
+			parts := append([]string{srcRoot}, strings.Split(filepath.ToSlash(relPath), "/")...)
+			root.insert(parts, &repb.FileNode{
+				Name:         parts[len(parts)-1],
+				Digest:       &repb.Digest{Hash: digest, SizeBytes: size},
+				IsExecutable: finfo.Mode()&0111 != 0,
+			})
+			return nil
+		})
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	digest, err := root.upload(ctx, r.cas)
+	if err != nil {
+		return "", 0, err
+	}
+	return digest.Hash, digest.SizeBytes, nil
+}
+
+// uploadAction uploads the Command (argv, env pairs, AS-derived uid/gid
+// platform properties) alongside the input root digest and returns the
+// digest of the resulting Action, which is the cache key.
+func (r *RemoteExecutor) uploadAction(ctx context.Context, inputDigest string, inputSize int64, env []string, uid, gid uint32, argv []string) (string, error) {
+	var envVars []*repb.Command_EnvironmentVariable
+	for _, e := range env {
+		name, value, ok := strings.Cut(e, "=")
+		if !ok {
+			continue
+		}
+		envVars = append(envVars, &repb.Command_EnvironmentVariable{Name: name, Value: value})
+	}
+	cmd := &repb.Command{
+		Arguments:            argv,
+		EnvironmentVariables: envVars,
+		Platform: &repb.Platform{Properties: []*repb.Platform_Property{
+			{Name: "uid", Value: strconv.FormatUint(uint64(uid), 10)},
+			{Name: "gid", Value: strconv.FormatUint(uint64(gid), 10)},
+		}},
+	}
+	cmdData, err := proto.Marshal(cmd)
+	if err != nil {
+		return "", err
+	}
+	cmdDigest, cmdSize, err := r.cas.Put(ctx, cmdData)
+	if err != nil {
+		return "", err
+	}
+
+	action := &repb.Action{
+		CommandDigest:   &repb.Digest{Hash: cmdDigest, SizeBytes: cmdSize},
+		InputRootDigest: &repb.Digest{Hash: inputDigest, SizeBytes: inputSize},
+	}
+	actionData, err := proto.Marshal(action)
+	if err != nil {
+		return "", err
+	}
+	digest, _, err := r.cas.Put(ctx, actionData)
+	return digest, err
+}
+
+// dialRemote establishes the CAS + Execution client pair for the service
+// described by cmd, rooting on-disk state under workdir.
+//
+// An address of "" or "local" resolves to localREBackend, the same-host
+// reference implementation (see its doc comment); this is what lets
+// existing scripts and tests exercise the Dispatch/cache pipeline without
+// a real RE service. Any other address is dialed for real over gRPC and
+// wrapped in grpcREBackend, which drives the CAS and Execution services of
+// an actual buildbarn/buildfarm-style server.
+func dialRemote(cmd *script.RemoteCommand, workdir string) (reBlobStore, reExecutionClient, error) {
+	switch cmd.Address() {
+	case "", "local":
+		backend := newLocalREBackend(filepath.Join(workdir, ".remote-cas"))
+		logrus.Debugf("remote: address %q resolves to the local reference CAS backend", cmd.Address())
+		return backend, backend, nil
+	}
+
+	conn, err := grpc.Dial(cmd.Address(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("remote: dial %s: %s", cmd.Address(), err)
+	}
+	backend := newGrpcREBackend(conn, cmd.Instance())
+	return backend, backend, nil
+}
+
+// localREBackend is a same-host reBlobStore + reExecutionClient pair: Put
+// writes a blob keyed by its own sha256 digest under dir, Get reads it
+// back, and Execute resolves an action digest to its Command and input
+// root manifest (both fetched from the same store), materializes the
+// input root into a scratch directory, and runs the Command there under
+// uid:gid. It stands in for a real RE service so RemoteExecutor's
+// upload/cache/dispatch path can be exercised without one.
+type localREBackend struct {
+	dir string
+}
+
+func newLocalREBackend(dir string) *localREBackend {
+	return &localREBackend{dir: dir}
+}
+
+func (b *localREBackend) blobPath(digest string) string {
+	return filepath.Join(b.dir, digest)
+}
+
+func (b *localREBackend) Put(ctx context.Context, data []byte) (string, int64, error) {
+	digest := sha256Hex(data)
+	if err := os.MkdirAll(b.dir, 0744); err != nil && !os.IsExist(err) {
+		return "", 0, err
+	}
+	if err := ioutil.WriteFile(b.blobPath(digest), data, 0644); err != nil {
+		return "", 0, err
+	}
+	return digest, int64(len(data)), nil
+}
+
+// Get reads the blob back by its digest hash; size is unused since blobs are
+// keyed by filename on disk rather than a (hash,size) pair.
+func (b *localREBackend) Get(ctx context.Context, digest string, size int64) ([]byte, error) {
+	return ioutil.ReadFile(b.blobPath(digest))
+}
+
+func (b *localREBackend) Execute(ctx context.Context, actionDigest string) (*actionResult, error) {
+	actionData, err := b.Get(ctx, actionDigest, 0)
+	if err != nil {
+		return nil, fmt.Errorf("action %s not found in CAS: %s", actionDigest, err)
+	}
+	var action repb.Action
+	if err := proto.Unmarshal(actionData, &action); err != nil {
+		return nil, fmt.Errorf("action %s: %s", actionDigest, err)
+	}
+
+	cmdData, err := b.Get(ctx, action.GetCommandDigest().GetHash(), action.GetCommandDigest().GetSizeBytes())
+	if err != nil {
+		return nil, fmt.Errorf("command %s not found in CAS: %s", action.GetCommandDigest().GetHash(), err)
+	}
+	var cmd repb.Command
+	if err := proto.Unmarshal(cmdData, &cmd); err != nil {
+		return nil, fmt.Errorf("command %s: %s", action.GetCommandDigest().GetHash(), err)
+	}
+	if len(cmd.Arguments) == 0 {
+		return nil, fmt.Errorf("action %s: empty argv", actionDigest)
+	}
+	uid, gid, err := platformCredentials(cmd.GetPlatform())
+	if err != nil {
+		return nil, fmt.Errorf("action %s: %s", actionDigest, err)
+	}
+
+	inputRoot, err := ioutil.TempDir(b.dir, "input-root-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(inputRoot)
+	if err := b.materializeDirectory(ctx, action.GetInputRootDigest(), inputRoot); err != nil {
+		return nil, fmt.Errorf("action %s: materialize input root: %s", actionDigest, err)
+	}
+
+	c := osexec.CommandContext(ctx, cmd.Arguments[0], cmd.Arguments[1:]...)
+	c.Dir = inputRoot
+	c.Env = commandEnv(cmd.EnvironmentVariables)
+	c.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uid, Gid: gid}}
+
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+
+	exitCode := int32(0)
+	if runErr := c.Run(); runErr != nil {
+		exitErr, ok := runErr.(*osexec.ExitError)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s", cmd.Arguments[0], runErr)
+		}
+		exitCode = int32(exitErr.ExitCode())
+	}
+	return &actionResult{ExitCode: exitCode, Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, nil
+}
+
+// materializeDirectory replays the Directory proto at dirDigest (written by
+// uploadInputRoot) into destDir, recursing into subdirectories and
+// fetching each file's content from the CAS.
+func (b *localREBackend) materializeDirectory(ctx context.Context, dirDigest *repb.Digest, destDir string) error {
+	if dirDigest.GetHash() == "" {
+		return nil
+	}
+	dirData, err := b.Get(ctx, dirDigest.GetHash(), dirDigest.GetSizeBytes())
+	if err != nil {
+		return err
+	}
+	var dir repb.Directory
+	if err := proto.Unmarshal(dirData, &dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(destDir, 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+	for _, f := range dir.Files {
+		data, err := b.Get(ctx, f.GetDigest().GetHash(), f.GetDigest().GetSizeBytes())
+		if err != nil {
+			return fmt.Errorf("%s: %s", f.Name, err)
+		}
+		mode := os.FileMode(0644)
+		if f.IsExecutable {
+			mode = 0755
+		}
+		if err := ioutil.WriteFile(filepath.Join(destDir, f.Name), data, mode); err != nil {
+			return err
+		}
+	}
+	for _, d := range dir.Directories {
+		if err := b.materializeDirectory(ctx, d.GetDigest(), filepath.Join(destDir, d.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandEnv reconstitutes a Command's environment variable pairs into the
+// "name=value" form os/exec expects.
+func commandEnv(vars []*repb.Command_EnvironmentVariable) []string {
+	env := make([]string, len(vars))
+	for i, v := range vars {
+		env[i] = v.Name + "=" + v.Value
+	}
+	return env
+}
+
+// platformCredentials extracts the AS-derived uid/gid platform properties
+// written by uploadAction back out of a Command's Platform.
+func platformCredentials(p *repb.Platform) (uid, gid uint32, err error) {
+	for _, prop := range p.GetProperties() {
+		v, err := strconv.ParseUint(prop.Value, 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("platform property %s=%s: %s", prop.Name, prop.Value, err)
+		}
+		switch prop.Name {
+		case "uid":
+			uid = uint32(v)
+		case "gid":
+			gid = uint32(v)
+		}
+	}
+	return uid, gid, nil
+}
+
+// actionCache is a simple on-disk cache keyed by (machine, action digest)
+// so that re-running an unchanged script skips actions already executed
+// remotely. Machine is part of the key, not just the digest, because
+// nothing in the Action/Command proto identifies which FROM source it ran
+// on: two machines dispatching the identical command would otherwise
+// collide on one cache entry and only the first machine would actually
+// execute it.
+type actionCache struct {
+	dir string
+}
+
+func newActionCache(dir string) *actionCache {
+	return &actionCache{dir: dir}
+}
+
+func (c *actionCache) path(machine, digest string) string {
+	return filepath.Join(c.dir, sanitizeFilename(machine), digest+".json")
+}
+
+func (c *actionCache) Lookup(machine, digest string) (*actionResult, bool) {
+	data, err := ioutil.ReadFile(c.path(machine, digest))
+	if err != nil {
+		return nil, false
+	}
+	var result actionResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		logrus.Warnf("remote: discarding corrupt cache entry %s/%s: %s", machine, digest, err)
+		return nil, false
+	}
+	return &result, true
+}
+
+func (c *actionCache) Store(machine, digest string, result *actionResult) {
+	dir := filepath.Join(c.dir, sanitizeFilename(machine))
+	if err := os.MkdirAll(dir, 0744); err != nil && !os.IsExist(err) {
+		logrus.Warnf("remote: cache dir %s: %s", dir, err)
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		logrus.Warnf("remote: encode cache entry %s/%s: %s", machine, digest, err)
+		return
+	}
+	if err := ioutil.WriteFile(c.path(machine, digest), data, 0644); err != nil {
+		logrus.Warnf("remote: write cache entry %s/%s: %s", machine, digest, err)
+	}
+}
+
+// sanitizeFilename replaces path separators in name so it can be used as a
+// single path element (a FROM source's address may be a host:port pair or
+// similar, but must not be allowed to escape the cache directory).
+func sanitizeFilename(name string) string {
+	return strings.NewReplacer("/", "_", string(os.PathSeparator), "_").Replace(name)
+}
+
+// actionResult is the locally persisted subset of a remote ActionResult
+// needed to replay a cached action without re-contacting the CAS.
+type actionResult struct {
+	ExitCode int32             `json:"exitCode"`
+	Stdout   []byte            `json:"stdout"`
+	Stderr   []byte            `json:"stderr"`
+	Outputs  map[string][]byte `json:"outputs"`
+}
+
+// writeActionResultFiles writes result's stdout/stderr under outDir, named
+// "<name>.stdout.txt"/"<name>.stderr.txt" so that a second Dispatch call
+// against the same outDir (e.g. a second CAPTURE under the same FROM
+// source) doesn't overwrite the first command's output the way a fixed
+// "stdout.txt"/"stderr.txt" name would.
+func writeActionResultFiles(result *actionResult, outDir, name string) error {
+	if err := os.MkdirAll(outDir, 0744); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := writeFile(bytes.NewReader(result.Stdout), filepath.Join(outDir, name+".stdout.txt")); err != nil {
+		return err
+	}
+	if err := writeFile(bytes.NewReader(result.Stderr), filepath.Join(outDir, name+".stderr.txt")); err != nil {
+		return err
+	}
+	for outName, content := range result.Outputs {
+		if err := writeFile(bytes.NewReader(content), filepath.Join(outDir, outName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+package exec
+
+import (
+	"context"
+	"sort"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// dirNode is an in-memory tree used to assemble the real Directory proto
+// hierarchy uploadInputRoot uploads to the CAS: files are collected by
+// path, then the tree is serialized bottom-up so every subdirectory is
+// uploaded (and digested) before the DirectoryNode referencing it.
+type dirNode struct {
+	files map[string]*repb.FileNode
+	dirs  map[string]*dirNode
+}
+
+func newDirNode() *dirNode {
+	return &dirNode{files: make(map[string]*repb.FileNode), dirs: make(map[string]*dirNode)}
+}
+
+// insert places fn at the path named by parts, creating intermediate
+// directories as needed. parts' final element is the file's own name.
+func (d *dirNode) insert(parts []string, fn *repb.FileNode) {
+	if len(parts) == 1 {
+		d.files[parts[0]] = fn
+		return
+	}
+	child, ok := d.dirs[parts[0]]
+	if !ok {
+		child = newDirNode()
+		d.dirs[parts[0]] = child
+	}
+	child.insert(parts[1:], fn)
+}
+
+// upload serializes d as a Directory proto, recursing into subdirectories
+// first so it can reference each by the digest of its own serialized form,
+// and returns the digest of the resulting blob.
+func (d *dirNode) upload(ctx context.Context, cas reBlobStore) (*repb.Digest, error) {
+	dir := &repb.Directory{}
+
+	names := make([]string, 0, len(d.files))
+	for name := range d.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dir.Files = append(dir.Files, d.files[name])
+	}
+
+	dirNames := make([]string, 0, len(d.dirs))
+	for name := range d.dirs {
+		dirNames = append(dirNames, name)
+	}
+	sort.Strings(dirNames)
+	for _, name := range dirNames {
+		digest, err := d.dirs[name].upload(ctx, cas)
+		if err != nil {
+			return nil, err
+		}
+		dir.Directories = append(dir.Directories, &repb.DirectoryNode{Name: name, Digest: digest})
+	}
+
+	data, err := proto.Marshal(dir)
+	if err != nil {
+		return nil, err
+	}
+	hash, size, err := cas.Put(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return &repb.Digest{Hash: hash, SizeBytes: size}, nil
+}
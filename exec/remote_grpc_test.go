@@ -0,0 +1,123 @@
+package exec
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	longrunningpb "cloud.google.com/go/longrunning/autogen/longrunningpb"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// fakeREServer is a minimal Bazel Remote Execution API service that, unlike
+// localREBackend, behaves like a real buildbarn/buildfarm deployment: it
+// never inlines stdout/stderr on the ActionResult it returns from Execute,
+// only a StdoutDigest/StderrDigest pointing back into its own CAS.
+type fakeREServer struct {
+	repb.UnimplementedExecutionServer
+	repb.UnimplementedContentAddressableStorageServer
+
+	blobs map[string][]byte
+}
+
+func newFakeREServer() *fakeREServer {
+	return &fakeREServer{blobs: make(map[string][]byte)}
+}
+
+func (s *fakeREServer) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlobsRequest) (*repb.BatchUpdateBlobsResponse, error) {
+	resp := &repb.BatchUpdateBlobsResponse{}
+	for _, r := range req.Requests {
+		s.blobs[r.GetDigest().GetHash()] = r.Data
+		resp.Responses = append(resp.Responses, &repb.BatchUpdateBlobsResponse_Response{Digest: r.Digest})
+	}
+	return resp, nil
+}
+
+func (s *fakeREServer) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsRequest) (*repb.BatchReadBlobsResponse, error) {
+	resp := &repb.BatchReadBlobsResponse{}
+	for _, d := range req.Digests {
+		data := s.blobs[d.Hash]
+		resp.Responses = append(resp.Responses, &repb.BatchReadBlobsResponse_Response{Digest: d, Data: data})
+	}
+	return resp, nil
+}
+
+// Execute ignores the submitted action entirely and reports stdout/stderr
+// purely by digest, the way a server is expected to behave when it decides
+// the output doesn't fit inline even though InlineStdout/InlineStderr were
+// set on the request.
+func (s *fakeREServer) Execute(req *repb.ExecuteRequest, stream grpc.ServerStreamingServer[longrunningpb.Operation]) error {
+	stdoutDigest := s.put([]byte("hello from stdout\n"))
+	stderrDigest := s.put([]byte("hello from stderr\n"))
+	outputDigest := s.put([]byte("output file contents"))
+
+	result := &repb.ActionResult{
+		ExitCode:     0,
+		StdoutDigest: stdoutDigest,
+		StderrDigest: stderrDigest,
+		OutputFiles: []*repb.OutputFile{
+			{Path: "result.txt", Digest: outputDigest},
+		},
+	}
+	execResp := &repb.ExecuteResponse{Result: result}
+	any, err := anypb.New(execResp)
+	if err != nil {
+		return err
+	}
+	return stream.Send(&longrunningpb.Operation{Done: true, Result: &longrunningpb.Operation_Response{Response: any}})
+}
+
+func (s *fakeREServer) put(data []byte) *repb.Digest {
+	digest := sha256Hex(data)
+	s.blobs[digest] = data
+	return &repb.Digest{Hash: digest, SizeBytes: int64(len(data))}
+}
+
+// dialFakeREServer starts fakeREServer on a loopback listener and returns a
+// grpcREBackend dialed against it, plus a cleanup func.
+func dialFakeREServer(t *testing.T) (*grpcREBackend, func()) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	fake := newFakeREServer()
+	repb.RegisterExecutionServer(srv, fake)
+	repb.RegisterContentAddressableStorageServer(srv, fake)
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		lis.Close()
+		t.Fatal(err)
+	}
+
+	return newGrpcREBackend(conn, ""), func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func TestGrpcREBackendExecuteResolvesDigestOnlyOutput(t *testing.T) {
+	backend, cleanup := dialFakeREServer(t)
+	defer cleanup()
+
+	result, err := backend.Execute(context.Background(), "ignored-by-fake-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Stdout) != "hello from stdout\n" {
+		t.Errorf("stdout = %q, want digest-fetched content", result.Stdout)
+	}
+	if string(result.Stderr) != "hello from stderr\n" {
+		t.Errorf("stderr = %q, want digest-fetched content", result.Stderr)
+	}
+	if string(result.Outputs["result.txt"]) != "output file contents" {
+		t.Errorf("outputs[result.txt] = %q, want digest-fetched content", result.Outputs["result.txt"])
+	}
+}
@@ -0,0 +1,237 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+func mustProto(t *testing.T, m proto.Message) []byte {
+	t.Helper()
+	data, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRemoteExecutorDispatch(t *testing.T) {
+	workdir := t.TempDir()
+	backend := newLocalREBackend(filepath.Join(workdir, ".remote-cas"))
+	r := &RemoteExecutor{cas: backend, exec: backend, cache: newActionCache(filepath.Join(workdir, ".remote-cache"))}
+
+	copySrc := filepath.Join(workdir, "src")
+	if err := os.MkdirAll(copySrc, 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(copySrc, "note.txt"), []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := filepath.Join(workdir, "machine1")
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+
+	exitCode, err := r.Dispatch(context.Background(), "machine1", "echo", uid, gid, nil, []string{copySrc}, outDir, "/bin/echo", "HELLO WORLD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0", exitCode)
+	}
+
+	stdout, err := ioutil.ReadFile(filepath.Join(outDir, "echo.stdout.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stdout) != "HELLO WORLD\n" {
+		t.Errorf("stdout = %q, want %q", stdout, "HELLO WORLD\n")
+	}
+
+	// A second dispatch of the same action should be served from the
+	// action-result cache instead of executing again.
+	if err := os.RemoveAll(outDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Dispatch(context.Background(), "machine1", "echo", uid, gid, nil, []string{copySrc}, outDir, "/bin/echo", "HELLO WORLD"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "echo.stdout.txt")); err != nil {
+		t.Fatalf("expected cached dispatch to still write echo.stdout.txt: %s", err)
+	}
+}
+
+// TestRemoteExecutorDispatchNamesOutputPerCommand asserts two different
+// commands dispatched to the same outDir (e.g. two CAPTUREs under one
+// REMOTE source) each keep their own stdout/stderr files instead of the
+// second command's output silently overwriting the first's.
+func TestRemoteExecutorDispatchNamesOutputPerCommand(t *testing.T) {
+	workdir := t.TempDir()
+	backend := newLocalREBackend(filepath.Join(workdir, ".remote-cas"))
+	r := &RemoteExecutor{cas: backend, exec: backend, cache: newActionCache(filepath.Join(workdir, ".remote-cache"))}
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	outDir := filepath.Join(workdir, "machine1")
+
+	if _, err := r.Dispatch(context.Background(), "machine1", "echo_one", uid, gid, nil, nil, outDir, "/bin/echo", "ONE"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Dispatch(context.Background(), "machine1", "echo_two", uid, gid, nil, nil, outDir, "/bin/echo", "TWO"); err != nil {
+		t.Fatal(err)
+	}
+
+	one, err := ioutil.ReadFile(filepath.Join(outDir, "echo_one.stdout.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(one) != "ONE\n" {
+		t.Errorf("echo_one.stdout.txt = %q, want %q", one, "ONE\n")
+	}
+	two, err := ioutil.ReadFile(filepath.Join(outDir, "echo_two.stdout.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(two) != "TWO\n" {
+		t.Errorf("echo_two.stdout.txt = %q, want %q", two, "TWO\n")
+	}
+}
+
+// TestRemoteExecutorDispatchScopesCacheByMachine asserts two different
+// machines dispatching the identical command (same argv/env/uid/gid/input
+// root, which all hash to the same action digest) each actually execute,
+// rather than the second machine's result being served from the first
+// machine's cache entry.
+func TestRemoteExecutorDispatchScopesCacheByMachine(t *testing.T) {
+	workdir := t.TempDir()
+	backend := newLocalREBackend(filepath.Join(workdir, ".remote-cas"))
+	r := &RemoteExecutor{cas: backend, exec: backend, cache: newActionCache(filepath.Join(workdir, ".remote-cache"))}
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+
+	for _, machine := range []string{"machine1", "machine2"} {
+		outDir := filepath.Join(workdir, machine)
+		if _, err := r.Dispatch(context.Background(), machine, "echo", uid, gid, nil, nil, outDir, "/bin/echo", "HELLO"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(outDir, "echo.stdout.txt")); err != nil {
+			t.Errorf("%s: expected echo.stdout.txt to be written, got err=%v", machine, err)
+		}
+		if entries, err := ioutil.ReadDir(filepath.Join(workdir, ".remote-cache", machine)); err != nil || len(entries) == 0 {
+			t.Errorf("%s: expected its own action-cache entry under .remote-cache/%s, got entries=%v err=%v", machine, machine, entries, err)
+		}
+	}
+}
+
+func TestLocalREBackendFailingCommand(t *testing.T) {
+	backend := newLocalREBackend(t.TempDir())
+	ctx := context.Background()
+
+	inputDigest, inputSize, err := backend.Put(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &repb.Command{
+		Arguments: []string{"/bin/sh", "-c", "exit 3"},
+		Platform: &repb.Platform{Properties: []*repb.Platform_Property{
+			{Name: "uid", Value: fmt.Sprint(os.Getuid())},
+			{Name: "gid", Value: fmt.Sprint(os.Getgid())},
+		}},
+	}
+	cmdDigest, cmdSize, err := backend.Put(ctx, mustProto(t, cmd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	action := &repb.Action{
+		CommandDigest:   &repb.Digest{Hash: cmdDigest, SizeBytes: cmdSize},
+		InputRootDigest: &repb.Digest{Hash: inputDigest, SizeBytes: inputSize},
+	}
+	actionDigest, _, err := backend.Put(ctx, mustProto(t, action))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := backend.Execute(ctx, actionDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("exit code = %d, want 3", result.ExitCode)
+	}
+}
+
+func TestUploadInputRootAvoidsBasenameCollisions(t *testing.T) {
+	workdir := t.TempDir()
+	backend := newLocalREBackend(filepath.Join(workdir, ".remote-cas"))
+	r := &RemoteExecutor{cas: backend}
+
+	src1 := filepath.Join(workdir, "app1", "config")
+	src2 := filepath.Join(workdir, "app2", "config")
+	if err := os.MkdirAll(src1, 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(src2, 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src1, "settings.yaml"), []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src2, "settings.yaml"), []byte("two"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rootDigest, rootSize, err := r.uploadInputRoot(context.Background(), []string{src1, src2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	destDir := t.TempDir()
+	if err := backend.materializeDirectory(context.Background(), &repb.Digest{Hash: rootDigest, SizeBytes: rootSize}, destDir); err != nil {
+		t.Fatal(err)
+	}
+
+	got1, err := ioutil.ReadFile(filepath.Join(destDir, "src0", "config", "settings.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got2, err := ioutil.ReadFile(filepath.Join(destDir, "src1", "config", "settings.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got1) != "one" || string(got2) != "two" {
+		t.Errorf("got %q, %q; want \"one\", \"two\" (sources overwrote each other)", got1, got2)
+	}
+}
+
+func TestUploadInputRootPathWithSpace(t *testing.T) {
+	workdir := t.TempDir()
+	backend := newLocalREBackend(filepath.Join(workdir, ".remote-cas"))
+	r := &RemoteExecutor{cas: backend}
+
+	src := filepath.Join(workdir, "logs")
+	if err := os.MkdirAll(src, 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "kube apiserver.log"), []byte("boom"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rootDigest, rootSize, err := r.uploadInputRoot(context.Background(), []string{src})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	destDir := t.TempDir()
+	if err := backend.materializeDirectory(context.Background(), &repb.Digest{Hash: rootDigest, SizeBytes: rootSize}, destDir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(destDir, "src0", "logs", "kube apiserver.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "boom" {
+		t.Errorf("got %q, want %q", got, "boom")
+	}
+}
@@ -0,0 +1,68 @@
+package exec
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceEntry describes one resolved action - the substituted env, the
+// effective uid/gid, and the fully-expanded argv - whether or not it
+// actually ran.
+type traceEntry struct {
+	Machine    string
+	Action     string
+	Argv       []string
+	Env        []string
+	Uid, Gid   uint32
+	StartedAt  time.Time
+	FinishedAt time.Time
+	ExitStatus int
+	DryRun     bool
+}
+
+// traceRecorder appends one recfile record per resolved action to
+// workdir/trace.log, backing both --trace and --dry-run. Records are
+// flushed as actions resolve so a crash mid-run still leaves a readable
+// trace behind, and writes are serialized since sources may run
+// concurrently.
+type traceRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newTraceRecorder(path string) (*traceRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &traceRecorder{file: file}, nil
+}
+
+func (t *traceRecorder) Close() error {
+	return t.file.Close()
+}
+
+func (t *traceRecorder) Record(e traceEntry) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Machine: %s\n", e.Machine)
+	fmt.Fprintf(&b, "Action: %s\n", e.Action)
+	fmt.Fprintf(&b, "Argv: %s\n", strings.Join(e.Argv, " "))
+	fmt.Fprintf(&b, "Env: %s\n", strings.Join(e.Env, " "))
+	fmt.Fprintf(&b, "Uid: %d\n", e.Uid)
+	fmt.Fprintf(&b, "Gid: %d\n", e.Gid)
+	fmt.Fprintf(&b, "DryRun: %t\n", e.DryRun)
+	fmt.Fprintf(&b, "StartedAt: %s\n", formatTAI64N(e.StartedAt))
+	fmt.Fprintf(&b, "FinishedAt: %s\n", formatTAI64N(e.FinishedAt))
+	fmt.Fprintf(&b, "Duration: %s\n", e.FinishedAt.Sub(e.StartedAt))
+	fmt.Fprintf(&b, "ExitStatus: %d\n\n", e.ExitStatus)
+
+	_, err := io.WriteString(t.file, b.String())
+	return err
+}
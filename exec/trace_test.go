@@ -0,0 +1,40 @@
+package exec
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceRecorderWritesRecfileEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	tracer, err := newTraceRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if err := tracer.Record(traceEntry{
+		Machine: "host1", Action: "CAPTURE", Argv: []string{"/bin/echo", "HELLO"},
+		Env: []string{"FOO=bar"}, Uid: 1000, Gid: 1000,
+		StartedAt: now, FinishedAt: now.Add(time.Millisecond), ExitStatus: 0,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{"Machine: host1", "Action: CAPTURE", "Argv: /bin/echo HELLO", "ExitStatus: 0"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("trace.log missing %q, got:\n%s", want, out)
+		}
+	}
+}
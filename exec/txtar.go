@@ -0,0 +1,102 @@
+package exec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/txtar"
+)
+
+// bundleExcluded names the workdir-relative entries that are internal
+// bookkeeping rather than captured diagnostics: manifest.rec (IFCHANGE/COPY
+// incremental state), trace.log (--trace/--dry-run records), and the
+// .remote-cache/.remote-cas trees (RemoteExecutor's action-result cache and
+// CAS). None of these belong in a bundle meant to be a small, shareable
+// attachment, and re-materializing the cache/CAS trees on unbundle would
+// just resurrect internal state as ordinary files.
+var bundleExcluded = map[string]bool{
+	"manifest.rec":  true,
+	"trace.log":     true,
+	".remote-cache": true,
+	".remote-cas":   true,
+}
+
+// bundleTxtar streams every captured file under workdir into a single
+// txtar (https://pkg.go.dev/golang.org/x/tools/txtar) archive at
+// archivePath, one "-- name --" section per file, with name set to the
+// path relative to workdir (e.g. "machine1/bin_echo_HELLO.stdout.txt"). This
+// mirrors the bundle format the Go toolchain uses for its script-driven
+// tests and makes a diagnostics run trivially attachable to a bug report
+// as one plain-text file.
+func bundleTxtar(workdir, archivePath string) error {
+	// archivePath's own default location nests inside the default workdir
+	// (Defaults.OutputValue sits under Defaults.WorkdirValue), so a second
+	// bundling run would otherwise walk straight into the first run's
+	// archive and embed it as a file whose content itself contains
+	// "-- name --" section markers, corrupting the outer archive's framing
+	// on re-parse. Exclude it the same way the other internal-state
+	// entries are excluded.
+	archiveRel, archiveRelErr := filepath.Rel(workdir, archivePath)
+	archiveRel = filepath.ToSlash(archiveRel)
+
+	var archive txtar.Archive
+	err := filepath.Walk(workdir, func(file string, finfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(workdir, file)
+		if err != nil {
+			return err
+		}
+		relSlash := filepath.ToSlash(relPath)
+		if archiveRelErr == nil && relSlash == archiveRel {
+			return nil
+		}
+		if top := strings.SplitN(relSlash, "/", 2)[0]; bundleExcluded[top] {
+			if finfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if finfo.IsDir() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		archive.Files = append(archive.Files, txtar.File{
+			Name: relSlash,
+			Data: data,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(archivePath, txtar.Format(&archive), 0644)
+}
+
+// Unbundle round-trips a txtar archive created by bundleTxtar back into a
+// directory tree rooted at destDir. It is callable from Go only: this tree
+// has no `crash-diagnostics unbundle` subcommand or other CLI entrypoint.
+func Unbundle(archivePath, destDir string) error {
+	data, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		return err
+	}
+
+	archive := txtar.Parse(data)
+	for _, file := range archive.Files {
+		destPath := filepath.Join(destDir, filepath.FromSlash(file.Name))
+		if err := os.MkdirAll(filepath.Dir(destPath), 0744); err != nil && !os.IsExist(err) {
+			return err
+		}
+		if err := ioutil.WriteFile(destPath, file.Data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package exec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+func TestBundleUnbundleTxtar(t *testing.T) {
+	workdir := t.TempDir()
+	machineDir := filepath.Join(workdir, "machine1")
+	if err := os.MkdirAll(machineDir, 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(machineDir, "bin_echo_HELLO.txt"), []byte("HELLO WORLD\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "bundle.txtar")
+	if err := bundleTxtar(workdir, archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	destDir := t.TempDir()
+	if err := Unbundle(archivePath, destDir); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(destDir, "machine1", "bin_echo_HELLO.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "HELLO WORLD\n" {
+		t.Errorf("unbundled content = %q, want %q", got, "HELLO WORLD\n")
+	}
+}
+
+func TestBundleTxtarExcludesInternalState(t *testing.T) {
+	workdir := t.TempDir()
+	machineDir := filepath.Join(workdir, "machine1")
+	if err := os.MkdirAll(machineDir, 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(machineDir, "bin_echo_HELLO.txt"), []byte("HELLO WORLD\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workdir, "manifest.rec"), []byte("Machine: m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workdir, "trace.log"), []byte("Action: RUN\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(workdir, ".remote-cache"), 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workdir, ".remote-cache", "deadbeef.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(workdir, ".remote-cas"), 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workdir, ".remote-cas", "deadbeef"), []byte("blob"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "bundle.txtar")
+	if err := bundleTxtar(workdir, archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	destDir := t.TempDir()
+	if err := Unbundle(archivePath, destDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "machine1", "bin_echo_HELLO.txt")); err != nil {
+		t.Fatalf("expected captured file to survive bundling: %s", err)
+	}
+	for _, excluded := range []string{"manifest.rec", "trace.log", filepath.Join(".remote-cache", "deadbeef.json"), filepath.Join(".remote-cas", "deadbeef")} {
+		if _, err := os.Stat(filepath.Join(destDir, excluded)); !os.IsNotExist(err) {
+			t.Errorf("%s: expected to be excluded from bundle, got err=%v", excluded, err)
+		}
+	}
+}
+
+// TestBundleTxtarExcludesOwnArchive asserts a second bundling run, with the
+// archive's own destination nested inside workdir (as it is by default:
+// Defaults.OutputValue sits under Defaults.WorkdirValue), doesn't walk
+// into and embed the first run's archive. Embedding it would splice the
+// first archive's own "-- name --" section markers into the second
+// archive's content, corrupting the outer archive's framing on re-parse.
+func TestBundleTxtarExcludesOwnArchive(t *testing.T) {
+	workdir := t.TempDir()
+	machineDir := filepath.Join(workdir, "machine1")
+	if err := os.MkdirAll(machineDir, 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(machineDir, "bin_echo_HELLO.txt"), []byte("HELLO WORLD\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(workdir, "out.txtar")
+	if err := bundleTxtar(workdir, archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bundle again, now that out.txtar exists inside workdir.
+	if err := bundleTxtar(workdir, archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := txtar.Parse(data)
+	for _, file := range archive.Files {
+		if file.Name == "out.txtar" {
+			t.Fatalf("bundle embedded its own archive as %q, which would corrupt re-parsing", file.Name)
+		}
+	}
+
+	destDir := t.TempDir()
+	if err := Unbundle(archivePath, destDir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(destDir, "machine1", "bin_echo_HELLO.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "HELLO WORLD\n" {
+		t.Errorf("unbundled content = %q, want %q", got, "HELLO WORLD\n")
+	}
+}
@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commandSplit tokenizes s on whitespace, treating single- or
+// double-quoted substrings as part of the current token (the quote
+// characters themselves are stripped). It underlies both mapArgs (splitting
+// a raw argument string into "name:value" tokens) and cmdParse (splitting a
+// CAPTURE/RUN command line into argv), so a quoted value may contain
+// spaces in either position.
+func commandSplit(s string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	var quote rune
+	inWord := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				continue
+			}
+			cur.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
+
+// namedParamSplit splits a single "name:value" token (already unquoted by
+// commandSplit) into its name and value parts.
+func namedParamSplit(param string) (name, value string, err error) {
+	idx := strings.Index(param, ":")
+	if idx < 0 {
+		return "", "", fmt.Errorf("%q is not a name:value parameter", param)
+	}
+	return param[:idx], param[idx+1:], nil
+}
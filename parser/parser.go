@@ -89,6 +89,24 @@ func Parse(reader io.Reader) (*script.Script, error) {
 				return nil, err
 			}
 			scr.Preambles[script.CmdOutput] = []script.Command{cmd}
+		case script.CmdJobs:
+			cmd, err := script.NewJobsCommand(line, rawArgs)
+			if err != nil {
+				return nil, err
+			}
+			scr.Preambles[script.CmdJobs] = []script.Command{cmd} // saves only last JOBS
+		case script.CmdLogs:
+			cmd, err := script.NewLogsCommand(line, rawArgs)
+			if err != nil {
+				return nil, err
+			}
+			scr.Preambles[script.CmdLogs] = []script.Command{cmd} // saves only last LOGS
+		case script.CmdRemote:
+			cmd, err := script.NewRemoteCommand(line, rawArgs)
+			if err != nil {
+				return nil, err
+			}
+			scr.Preambles[script.CmdRemote] = []script.Command{cmd} // saves only last REMOTE
 		case script.CmdWorkDir:
 			cmd, err := script.NewWorkdirCommand(line, rawArgs)
 			if err != nil {
@@ -101,6 +119,12 @@ func Parse(reader io.Reader) (*script.Script, error) {
 				return nil, err
 			}
 			scr.Actions = append(scr.Actions, cmd)
+		case script.CmdIfChange:
+			cmd, err := script.NewIfChangeCommand(line, rawArgs)
+			if err != nil {
+				return nil, err
+			}
+			scr.Actions = append(scr.Actions, cmd)
 		case script.CmdCopy:
 			cmd, err := script.NewCopyCommand(line, rawArgs)
 			if err != nil {
@@ -245,11 +269,11 @@ func enforceDefaults(scr *script.Script) (*script.Script, error) {
 	}
 
 	if _, ok := scr.Preambles[script.CmdOutput]; !ok {
-		cmd, err := script.NewOutputCommand(0, fmt.Sprintf("path:%s", script.Defaults.OutputValue))
+		cmd, err := script.NewOutputCommand(0, fmt.Sprintf("path:%s format:%s", script.Defaults.OutputValue, script.Defaults.OutputFormat))
 		if err != nil {
 			return nil, err
 		}
-		logrus.Debugf("OUTPUT %s (as default)", cmd.Path())
+		logrus.Debugf("OUTPUT %s format:%s (as default)", cmd.Path(), cmd.Format())
 		scr.Preambles[script.CmdOutput] = []script.Command{cmd}
 	}
 
@@ -0,0 +1,142 @@
+package script
+
+// NOTE: see the package doc comment in script.go — every directive and
+// parsing helper in this package, this file included, is unreconciled
+// against upstream and the package isn't merge-ready as-is.
+
+import "fmt"
+
+// CaptureCommand is the CAPTURE action: run a CLI command on a FROM
+// source and save its output.
+type CaptureCommand struct {
+	line    int
+	cliStr  string
+	cliCmd  string
+	cliArgs []string
+}
+
+func (c *CaptureCommand) Name() string { return CmdCapture }
+
+// GetCliString returns the action's original, unparsed command line (used
+// to key manifest records and derive output file names).
+func (c *CaptureCommand) GetCliString() string { return c.cliStr }
+
+// GetParsedCli returns the command line split into its executable and
+// arguments, ready to pass to exec.Command.
+func (c *CaptureCommand) GetParsedCli() (string, []string) { return c.cliCmd, c.cliArgs }
+
+func NewCaptureCommand(line int, rawArgs string) (*CaptureCommand, error) {
+	cmd, args, err := splitCliString(CmdCapture, rawArgs)
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureCommand{line: line, cliStr: rawArgs, cliCmd: cmd, cliArgs: args}, nil
+}
+
+// RunCommand is the RUN action: run a CLI command on a FROM source,
+// discarding its output (used for side effects, e.g. priming state before
+// a later CAPTURE).
+type RunCommand struct {
+	line    int
+	cliCmd  string
+	cliArgs []string
+}
+
+func (c *RunCommand) Name() string { return CmdRun }
+
+func (c *RunCommand) GetParsedCli() (string, []string) { return c.cliCmd, c.cliArgs }
+
+func NewRunCommand(line int, rawArgs string) (*RunCommand, error) {
+	cmd, args, err := splitCliString(CmdRun, rawArgs)
+	if err != nil {
+		return nil, err
+	}
+	return &RunCommand{line: line, cliCmd: cmd, cliArgs: args}, nil
+}
+
+// CopyCommand is the COPY action: copy local files/directories into each
+// FROM source's output subtree (and, when REMOTE is set, into the input
+// root uploaded alongside every later CAPTURE/RUN).
+type CopyCommand struct {
+	line int
+	args []string
+}
+
+func (c *CopyCommand) Name() string   { return CmdCopy }
+func (c *CopyCommand) Args() []string { return c.args }
+
+func NewCopyCommand(line int, rawArgs string) (*CopyCommand, error) {
+	args, err := splitWords(rawArgs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", CmdCopy, err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: at least one path is required", CmdCopy)
+	}
+	return &CopyCommand{line: line, args: args}, nil
+}
+
+// IfChangeCommand is the IFCHANGE action: declares the paths whose
+// content digests the following CAPTURE is conditioned on, so it can be
+// skipped on a resumed run when none of them changed (mirrors djb-redo's
+// .rec dependency tracking).
+type IfChangeCommand struct {
+	line  int
+	paths []string
+}
+
+func (c *IfChangeCommand) Name() string    { return CmdIfChange }
+func (c *IfChangeCommand) Paths() []string { return c.paths }
+
+func NewIfChangeCommand(line int, rawArgs string) (*IfChangeCommand, error) {
+	tokens, err := splitWords(rawArgs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", CmdIfChange, err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: at least one path is required", CmdIfChange)
+	}
+	paths := make([]string, len(tokens))
+	for i, tok := range tokens {
+		paths[i] = tok
+		if len(tok) > len("path:") && tok[:len("path:")] == "path:" {
+			paths[i] = tok[len("path:"):]
+		}
+	}
+	return &IfChangeCommand{line: line, paths: paths}, nil
+}
+
+// KubeGetCommand is the KUBEGET action naming one or more Kubernetes
+// resources. It is parsed only: exec.Executor has no KUBEGET branch, so
+// nothing is fetched for it.
+type KubeGetCommand struct {
+	line      int
+	resources []string
+}
+
+func (c *KubeGetCommand) Name() string        { return CmdKubeGet }
+func (c *KubeGetCommand) Resources() []string { return c.resources }
+
+func NewKubeGetCommand(line int, rawArgs string) (*KubeGetCommand, error) {
+	resources, err := splitWords(rawArgs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", CmdKubeGet, err)
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("%s: at least one resource is required", CmdKubeGet)
+	}
+	return &KubeGetCommand{line: line, resources: resources}, nil
+}
+
+// splitCliString splits a CAPTURE/RUN action's raw argument string into
+// its executable and arguments.
+func splitCliString(cmdName, rawArgs string) (string, []string, error) {
+	argv, err := splitWords(rawArgs)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %s", cmdName, err)
+	}
+	if len(argv) == 0 {
+		return "", nil, fmt.Errorf("%s: a command is required", cmdName)
+	}
+	return argv[0], argv[1:], nil
+}
@@ -0,0 +1,71 @@
+package script
+
+// NOTE: see the package doc comment in script.go — every directive and
+// parsing helper in this package, this file included, is unreconciled
+// against upstream and the package isn't merge-ready as-is.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitWords tokenizes s on whitespace, treating single- or double-quoted
+// substrings as part of the current word (the quote characters themselves
+// are stripped). It is used both to split a directive's raw argument
+// string into "name:value" tokens and to split a CAPTURE/RUN command line
+// into argv, so a quoted value may contain spaces in either position
+// (e.g. `path:"/var/log/my app"` or `CAPTURE /bin/echo "hello world"`).
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	var quote rune
+	inWord := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				continue
+			}
+			cur.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
+
+// parseNamedArgs splits raw into "key:value" tokens separated by
+// whitespace, where value may be single- or double-quoted to contain
+// spaces (e.g. `path:"/var/log" format:txtar`).
+func parseNamedArgs(raw string) (map[string]string, error) {
+	tokens, err := splitWords(raw)
+	if err != nil {
+		return nil, err
+	}
+	args := make(map[string]string, len(tokens))
+	for _, tok := range tokens {
+		idx := strings.Index(tok, ":")
+		if idx < 0 {
+			return nil, fmt.Errorf("malformed argument %q: expected name:value", tok)
+		}
+		args[tok[:idx]] = tok[idx+1:]
+	}
+	return args, nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2020 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package script defines the parsed representation of a crash-diagnostics
+// script file: the preamble directives (FROM, AS, WORKDIR, ...) and action
+// commands (CAPTURE, RUN, COPY, ...) that package parser turns raw script
+// text into, and that package exec walks to drive collection.
+//
+// NOT MERGE-READY: this package was reconstructed from scratch to unblock
+// a build that couldn't otherwise compile against its directive model, and
+// every existing directive (AS, FROM, ENV, WORKDIR, KUBECONFIG,
+// AUTHCONFIG, COPY, RUN, KUBEGET) and arg format was re-guessed without a
+// reference copy of the upstream script package this tree is meant to sit
+// alongside. Everything built on top of this package (parser, exec) only
+// proves those re-guessed semantics are self-consistent, not that they
+// match real crash-diagnostics scripts. This package, and every package
+// built against it, must be reconciled against (or replaced by) the real
+// upstream implementation before this series lands — it is not a
+// polish item to pick up after merge.
+package script
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Command is implemented by every preamble directive and action a script
+// can contain.
+type Command interface {
+	// Name returns the directive name that produced the command (e.g.
+	// "CAPTURE"), used for logging and error messages.
+	Name() string
+}
+
+// Script is the parsed representation of a script file: preamble
+// directives keyed by directive name (FROM/AS/WORKDIR may only ever hold
+// their last occurrence; ENV may repeat), plus the ordered list of actions
+// to run against each FROM source.
+type Script struct {
+	Preambles map[string][]Command
+	Actions   []Command
+}
+
+// Machine identifies one FROM source a script's actions run against.
+type Machine struct {
+	Address string
+}
+
+// CmdSpec describes a directive's arity and whether this build supports
+// it; Cmds is consulted by parser.Parse before dispatching on a directive
+// name.
+type CmdSpec struct {
+	Supported bool
+	MinArgs   int
+	MaxArgs   int // -1 means unbounded
+}
+
+// Directive names recognized by parser.Parse.
+const (
+	CmdAs         = "AS"
+	CmdEnv        = "ENV"
+	CmdFrom       = "FROM"
+	CmdKubeConfig = "KUBECONFIG"
+	CmdAuthConfig = "AUTHCONFIG"
+	CmdOutput     = "OUTPUT"
+	CmdWorkDir    = "WORKDIR"
+	CmdCapture    = "CAPTURE"
+	CmdCopy       = "COPY"
+	CmdRun        = "RUN"
+	CmdKubeGet    = "KUBEGET"
+	CmdJobs       = "JOBS"
+	CmdLogs       = "LOGS"
+	CmdRemote     = "REMOTE"
+	CmdIfChange   = "IFCHANGE"
+)
+
+// Cmds is the directive registry parser.Parse consults to validate a
+// directive name before dispatching to the matching NewXCommand
+// constructor; Parse checks only Supported, not MinArgs/MaxArgs. An entry
+// missing from this map (an unrecognized directive) reports
+// Supported: false via its zero value.
+var Cmds = map[string]CmdSpec{
+	CmdAs:         {Supported: true, MinArgs: 0, MaxArgs: -1},
+	CmdEnv:        {Supported: true, MinArgs: 1, MaxArgs: -1},
+	CmdFrom:       {Supported: true, MinArgs: 1, MaxArgs: -1},
+	CmdKubeConfig: {Supported: true, MinArgs: 1, MaxArgs: 1},
+	CmdAuthConfig: {Supported: true, MinArgs: 0, MaxArgs: -1},
+	CmdOutput:     {Supported: true, MinArgs: 1, MaxArgs: 2},
+	CmdWorkDir:    {Supported: true, MinArgs: 1, MaxArgs: 1},
+	CmdCapture:    {Supported: true, MinArgs: 1, MaxArgs: -1},
+	CmdCopy:       {Supported: true, MinArgs: 1, MaxArgs: -1},
+	CmdRun:        {Supported: true, MinArgs: 1, MaxArgs: -1},
+	CmdKubeGet:    {Supported: true, MinArgs: 1, MaxArgs: -1},
+	CmdJobs:       {Supported: true, MinArgs: 1, MaxArgs: 1},
+	CmdLogs:       {Supported: true, MinArgs: 0, MaxArgs: -1},
+	CmdRemote:     {Supported: true, MinArgs: 1, MaxArgs: -1},
+	CmdIfChange:   {Supported: true, MinArgs: 1, MaxArgs: -1},
+}
+
+// Defaults holds the values parser.enforceDefaults falls back to when a
+// script omits an optional preamble.
+var Defaults = struct {
+	FromValue       string
+	WorkdirValue    string
+	OutputValue     string
+	OutputFormat    string
+	KubeConfigValue string
+}{
+	FromValue:       "local",
+	WorkdirValue:    filepath.Join(os.TempDir(), "crash-diagnostics"),
+	OutputValue:     filepath.Join(os.TempDir(), "crash-diagnostics", "out.txtar"),
+	OutputFormat:    "dir",
+	KubeConfigValue: "${HOME}/.kube/config",
+}